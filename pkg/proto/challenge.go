@@ -0,0 +1,101 @@
+package proto
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultChallengeTTL is how long a server-issued Hello stays valid for a
+// matching Connect before ChallengeStore.Validate starts rejecting it.
+const DefaultChallengeTTL = 30 * time.Second
+
+// defaultChallengeStoreSize bounds the LRU so a flood of Hello requests
+// can't grow the store without limit; the oldest issued entry is evicted
+// first, whether or not it has been consumed.
+const defaultChallengeStoreSize = 4096
+
+type challengeEntry struct {
+	nonce    uint64
+	issued   time.Time
+	consumed bool
+}
+
+// ChallengeStore remembers challenges a server has issued in Hello so a
+// Connect can be matched back to one, rejecting signatures bound to a
+// challenge that is unknown, expired, or already consumed by an earlier
+// Connect (replay protection).
+type ChallengeStore struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	counter uint64
+
+	order   *list.List
+	entries map[uint64]*list.Element
+}
+
+// NewChallengeStore creates a ChallengeStore with the given TTL and maximum
+// number of outstanding entries. A ttl of zero uses DefaultChallengeTTL; a
+// maxSize of zero uses defaultChallengeStoreSize.
+func NewChallengeStore(ttl time.Duration, maxSize int) *ChallengeStore {
+	if ttl == 0 {
+		ttl = DefaultChallengeTTL
+	}
+	if maxSize == 0 {
+		maxSize = defaultChallengeStoreSize
+	}
+	return &ChallengeStore{
+		ttl:     ttl,
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[uint64]*list.Element),
+	}
+}
+
+// Issue prepares a fresh Hello, recording it in the store so a later
+// Connect bound to it can be validated.
+func (s *ChallengeStore) Issue() (*Hello, error) {
+	h := &Hello{
+		Nonce:  atomic.AddUint64(&s.counter, 1),
+		Issued: time.Now().Unix(),
+	}
+	h.Refresh()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.order.Len() >= s.maxSize {
+		oldest := s.order.Back()
+		if oldest != nil {
+			s.order.Remove(oldest)
+			delete(s.entries, oldest.Value.(*challengeEntry).nonce)
+		}
+	}
+
+	elem := s.order.PushFront(&challengeEntry{nonce: h.Nonce, issued: time.Unix(h.Issued, 0)})
+	s.entries[h.Nonce] = elem
+	return h, nil
+}
+
+// Validate checks that h was issued by this store, has not expired, and has
+// not already been consumed by an earlier Connect, then marks it consumed.
+func (s *ChallengeStore) Validate(h *Hello) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[h.Nonce]
+	if !ok {
+		return ErrChallengeUnknown
+	}
+	entry := elem.Value.(*challengeEntry)
+	if entry.consumed {
+		return ErrChallengeConsumed
+	}
+	if time.Since(entry.issued) > s.ttl {
+		return ErrChallengeExpired
+	}
+	entry.consumed = true
+	return nil
+}
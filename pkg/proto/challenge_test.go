@@ -0,0 +1,60 @@
+package proto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChallengeStoreValidate(t *testing.T) {
+	store := NewChallengeStore(30*time.Second, 0)
+
+	h, err := store.Issue()
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if err := store.Validate(h); err != nil {
+		t.Fatalf("Validate(fresh) = %v, want nil", err)
+	}
+	if err := store.Validate(h); err != ErrChallengeConsumed {
+		t.Fatalf("Validate(reused) = %v, want ErrChallengeConsumed", err)
+	}
+}
+
+func TestChallengeStoreUnknown(t *testing.T) {
+	store := NewChallengeStore(30*time.Second, 0)
+	h := &Hello{Nonce: 999}
+	if err := store.Validate(h); err != ErrChallengeUnknown {
+		t.Fatalf("Validate(unknown) = %v, want ErrChallengeUnknown", err)
+	}
+}
+
+func TestChallengeStoreExpiry(t *testing.T) {
+	store := NewChallengeStore(10*time.Millisecond, 0)
+	h, err := store.Issue()
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := store.Validate(h); err != ErrChallengeExpired {
+		t.Fatalf("Validate(expired) = %v, want ErrChallengeExpired", err)
+	}
+}
+
+func TestChallengeStoreEviction(t *testing.T) {
+	store := NewChallengeStore(time.Minute, 2)
+
+	first, err := store.Issue()
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if _, err := store.Issue(); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	if _, err := store.Issue(); err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+
+	if err := store.Validate(first); err != ErrChallengeUnknown {
+		t.Fatalf("Validate(evicted) = %v, want ErrChallengeUnknown", err)
+	}
+}
@@ -0,0 +1,175 @@
+package proto
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"time"
+)
+
+const (
+	defaultBackoffBase   = 250 * time.Millisecond
+	defaultBackoffCap    = 10 * time.Second
+	defaultBackoffJitter = time.Second
+)
+
+// HandshakeBackoff computes how long to wait before the nth retry (n starts
+// at 1 for the first retry) after a handshake attempt failed with lastErr.
+type HandshakeBackoff func(n int, lastErr error) time.Duration
+
+// DefaultHandshakeBackoff is truncated exponential backoff capped at 10s,
+// plus up to 1s of uniform jitter: min(cap, 2^n * base) + rand[0,1s).
+func DefaultHandshakeBackoff(n int, lastErr error) time.Duration {
+	d := defaultBackoffCap
+	if shift := uint(n); shift < 32 {
+		if scaled := defaultBackoffBase * time.Duration(1<<shift); scaled > 0 && scaled < defaultBackoffCap {
+			d = scaled
+		}
+	}
+	return d + time.Duration(rand.Int63n(int64(defaultBackoffJitter)))
+}
+
+// Client drives the Hello/Connect handshake against a server, retrying
+// transient failures with Backoff.
+type Client struct {
+	ACLKey string
+	PSK    []byte
+
+	// Dial opens a fresh connection for one handshake attempt.
+	Dial func(ctx context.Context) (net.Conn, error)
+
+	// Backoff defaults to DefaultHandshakeBackoff when nil.
+	Backoff HandshakeBackoff
+}
+
+// Handshake runs Dial/Hello/Connect, retrying with Backoff on transient
+// failure until ctx is done. Only an explicit rejection (ConnectResult
+// Welcome=false with a non-challenge Reason such as RejectAuth) is not
+// retried, since the server
+// has actively refused this client rather than hit a transient condition.
+// An expired/unknown/consumed-challenge rejection instead triggers a fresh
+// Hello round on the next attempt.
+func (c *Client) Handshake(ctx context.Context) (net.Conn, *ConnectResult, error) {
+	backoff := c.Backoff
+	if backoff == nil {
+		backoff = DefaultHandshakeBackoff
+	}
+
+	var lastErr error
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		conn, result, err := c.attempt(ctx)
+		if err == nil {
+			return conn, result, nil
+		}
+		if isTerminalHandshakeErr(err) {
+			return nil, nil, err
+		}
+		lastErr = err
+
+		timer := time.NewTimer(backoff(n, lastErr))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context) (net.Conn, *ConnectResult, error) {
+	conn, err := c.Dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, result, err := c.roundTrip(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !result.Welcome {
+		conn.Close()
+		switch result.Reason {
+		case RejectChallengeExpired:
+			return nil, nil, ErrChallengeExpired
+		case RejectChallengeUnknown:
+			return nil, nil, ErrChallengeUnknown
+		case RejectChallengeConsumed:
+			return nil, nil, ErrChallengeConsumed
+		default:
+			return nil, nil, ErrConnectRejected
+		}
+	}
+	return conn, result, nil
+}
+
+func (c *Client) roundTrip(conn net.Conn) (*Hello, *ConnectResult, error) {
+	helloBuf := make([]byte, (&Hello{}).Len())
+	if _, err := readFull(conn, helloBuf); err != nil {
+		return nil, nil, err
+	}
+	h := &Hello{}
+	if err := h.Decode(helloBuf); err != nil {
+		return nil, nil, err
+	}
+
+	connect := &Connect{ACLKey: c.ACLKey}
+	if err := connect.Sign(h.Bound(), c.PSK); err != nil {
+		return nil, nil, err
+	}
+	connectBuf, err := connect.Encode(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.Write(connectBuf); err != nil {
+		return nil, nil, err
+	}
+
+	// ConnectResult is itself length-framed (a 1-byte MsgLen at offset 2), so
+	// read the fixed 3-byte header first and only then the exact number of
+	// message bytes it declares. A lone conn.Read sized to "the common case"
+	// can come back short on a slow or fragmenting conn, which Decode can't
+	// tell apart from a genuinely malformed packet - and a short read was
+	// being misclassified as the transient ErrInvalidPacket instead of
+	// surfacing the server's actual (possibly terminal) result.
+	var header [3]byte
+	if _, err := readFull(conn, header[:]); err != nil {
+		return nil, nil, err
+	}
+	resultBuf := make([]byte, 3+int(header[2]))
+	copy(resultBuf, header[:])
+	if _, err := readFull(conn, resultBuf[3:]); err != nil {
+		return nil, nil, err
+	}
+	result := &ConnectResult{}
+	if err := result.Decode(resultBuf); err != nil {
+		return nil, nil, err
+	}
+	return h, result, nil
+}
+
+func readFull(conn net.Conn, buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := conn.Read(buf[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// isTerminalHandshakeErr reports whether err should short-circuit retrying
+// rather than trigger a fresh Hello round. Only a well-formed, explicit
+// auth rejection (Welcome=false with a non-challenge Reason) is terminal;
+// everything else - I/O errors, timeouts, malformed-packet errors from wire
+// corruption, and expired/unknown/consumed-challenge rejections - is
+// treated as transient and retried with a fresh Hello.
+func isTerminalHandshakeErr(err error) bool {
+	return err == ErrConnectRejected
+}
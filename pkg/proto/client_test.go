@@ -0,0 +1,99 @@
+package proto
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestConnectResultEncodeDecode(t *testing.T) {
+	c := &ConnectResult{Welcome: false, Reason: RejectChallengeExpired}
+	if err := c.EncodeMessage("retry with a fresh hello"); err != nil {
+		t.Fatalf("EncodeMessage: %v", err)
+	}
+
+	buf := c.Encode(nil)
+
+	got := &ConnectResult{}
+	if err := got.Decode(buf); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got.Welcome != c.Welcome || got.Reason != c.Reason || got.DecodeMessage() != c.DecodeMessage() {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", got, c)
+	}
+}
+
+func TestDefaultHandshakeBackoff(t *testing.T) {
+	// min(cap, 2^n * base) with n starting at 1: n=1 -> 2*base.
+	d := DefaultHandshakeBackoff(1, nil)
+	if d < 2*defaultBackoffBase || d >= 2*defaultBackoffBase+defaultBackoffJitter {
+		t.Fatalf("DefaultHandshakeBackoff(1) = %v, want in [%v, %v)", d, 2*defaultBackoffBase, 2*defaultBackoffBase+defaultBackoffJitter)
+	}
+
+	d = DefaultHandshakeBackoff(10, nil)
+	if d < defaultBackoffCap || d >= defaultBackoffCap+defaultBackoffJitter {
+		t.Fatalf("DefaultHandshakeBackoff(10) = %v, want capped in [%v, %v)", d, defaultBackoffCap, defaultBackoffCap+defaultBackoffJitter)
+	}
+}
+
+// TestRoundTripReadsFullSizeConnectResult proves a ConnectResult carrying a
+// full 31-byte RawMsg (34 bytes on the wire) is read back intact rather than
+// truncated by roundTrip's conn.Read, which used to be sized for the common
+// case and would misreport a short read as ErrInvalidPacket.
+func TestRoundTripReadsFullSizeConnectResult(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	msg := strings.Repeat("x", 31)
+	want := &ConnectResult{Welcome: false, Reason: RejectAuth}
+	if err := want.EncodeMessage(msg); err != nil {
+		t.Fatalf("EncodeMessage: %v", err)
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		h := &Hello{}
+		h.Refresh()
+		if _, err := serverConn.Write(h.Encode(nil)); err != nil {
+			serverErr <- err
+			return
+		}
+		// Drain the Connect the client writes; its exact contents aren't
+		// this test's concern, and a single Read suffices since the client
+		// performs a single conn.Write of the whole encoded Connect.
+		if _, err := serverConn.Read(make([]byte, 4096)); err != nil {
+			serverErr <- err
+			return
+		}
+		_, err := serverConn.Write(want.Encode(nil))
+		serverErr <- err
+	}()
+
+	c := &Client{ACLKey: "test-acl-key", PSK: []byte("shared-secret")}
+	_, got, err := c.roundTrip(clientConn)
+	if err != nil {
+		t.Fatalf("roundTrip: %v", err)
+	}
+	if got.Welcome != want.Welcome || got.Reason != want.Reason || got.DecodeMessage() != want.DecodeMessage() {
+		t.Fatalf("roundTrip result = %+v, want %+v", got, want)
+	}
+}
+
+func TestIsTerminalHandshakeErr(t *testing.T) {
+	cases := []struct {
+		err      error
+		terminal bool
+	}{
+		{ErrConnectRejected, true},
+		{ErrInvalidPacket, false},
+		{ErrChallengeExpired, false},
+		{ErrChallengeUnknown, false},
+		{ErrChallengeConsumed, false},
+	}
+	for _, tc := range cases {
+		if got := isTerminalHandshakeErr(tc.err); got != tc.terminal {
+			t.Errorf("isTerminalHandshakeErr(%v) = %v, want %v", tc.err, got, tc.terminal)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package proto
+
+import "errors"
+
+var (
+	ErrInvalidPacket  = errors.New("proto: invalid packet")
+	ErrContentTooLong = errors.New("proto: content too long")
+	ErrBufferTooShort = errors.New("proto: buffer too short")
+	ErrDecryption     = errors.New("proto: decryption failed")
+	ErrRekeyRequired  = errors.New("proto: nonce counter exhausted, rekey required")
+
+	ErrChallengeUnknown  = errors.New("proto: challenge not issued by this server")
+	ErrChallengeExpired  = errors.New("proto: challenge expired")
+	ErrChallengeConsumed = errors.New("proto: challenge already consumed")
+
+	ErrUnsupportedSuite = errors.New("proto: unsupported cipher suite")
+
+	ErrConnectRejected = errors.New("proto: server rejected connect")
+)
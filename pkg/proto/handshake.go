@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"crypto/hmac"
 	"crypto/rand"
-	"crypto/sha512"
 	"encoding/binary"
 )
 
@@ -13,13 +12,32 @@ var (
 )
 
 type Hello struct {
+	// Suites advertises the MAC and AEAD algorithms the server is willing
+	// to accept, as a bitmap of the Suite* constants. Zero means "legacy
+	// HMAC-SHA512 only", so older clients that don't understand Suites
+	// still interoperate.
+	Suites    uint16
 	Challenge [16]byte
+	// Nonce is a server-side monotonic counter identifying this challenge in
+	// the ChallengeStore, so a Connect can be matched back to the Hello that
+	// issued it even though the random Challenge bytes are opaque.
+	Nonce uint64
+	// Issued is the unix-second timestamp the challenge was handed out at,
+	// used by the ChallengeStore to reject stale challenges.
+	Issued int64
 }
 
 func (h *Hello) Encode(buf []byte) []byte {
 	buf = buf[0:0]
 	buf = append(buf, helloMagic...)
+	var suitesBin [2]byte
+	binary.BigEndian.PutUint16(suitesBin[:], h.Suites)
+	buf = append(buf, suitesBin[:]...)
 	buf = append(buf, h.Challenge[:]...)
+	var tail [16]byte
+	binary.BigEndian.PutUint64(tail[:8], h.Nonce)
+	binary.BigEndian.PutUint64(tail[8:], uint64(h.Issued))
+	buf = append(buf, tail[:]...)
 	return buf
 }
 
@@ -27,39 +45,100 @@ func (h *Hello) Decode(buf []byte) error {
 	if len(buf) < h.Len() || bytes.Compare(helloMagic, buf[:len(helloMagic)]) != 0 {
 		return ErrInvalidPacket
 	}
-	copy(h.Challenge[:16], buf[len(helloMagic):16+len(helloMagic)])
+	buf = buf[len(helloMagic):]
+	h.Suites = binary.BigEndian.Uint16(buf[:2])
+	buf = buf[2:]
+	copy(h.Challenge[:16], buf[:16])
+	h.Nonce = binary.BigEndian.Uint64(buf[16:24])
+	h.Issued = int64(binary.BigEndian.Uint64(buf[24:32]))
 	return nil
 }
 
 func (h *Hello) Len() int {
-	return len(helloMagic) + len(h.Challenge)
+	return len(helloMagic) + 2 + len(h.Challenge) + 16
 }
 
 func (h *Hello) Refresh() { rand.Read(h.Challenge[:]) }
 
+// Bound returns the bytes a Connect (or KeyExchange) signature is computed
+// over: the random challenge plus the nonce and issuance time that bind it
+// to a single entry in the ChallengeStore, so replaying an old Connect with
+// a stale Hello no longer verifies.
+func (h *Hello) Bound() []byte {
+	buf := make([]byte, 0, 32)
+	buf = append(buf, h.Challenge[:]...)
+	var tail [16]byte
+	binary.BigEndian.PutUint64(tail[:8], h.Nonce)
+	binary.BigEndian.PutUint64(tail[8:], uint64(h.Issued))
+	return append(buf, tail[:]...)
+}
+
 type Connect struct {
+	// SuiteID selects one of the MAC algorithms the server advertised in
+	// Hello.Suites. Zero means legacy HMAC-SHA512, so a Connect from a
+	// client that predates suite negotiation still verifies.
+	SuiteID   uint16
 	ACLKey    string
 	Signature []byte
 }
 
-func (c *Connect) HMAC(challenge []byte, psk []byte) []byte {
-	h := hmac.New(sha512.New, psk)
+// HMAC computes the Connect signature using the MAC algorithm c.SuiteID
+// selects, failing closed with ErrUnsupportedSuite rather than falling back
+// to a default if the suite is unknown. SuiteID itself is mixed into the
+// input so a tampered-with suite selection invalidates the signature
+// instead of silently re-verifying under a different algorithm.
+func (c *Connect) HMAC(challenge []byte, psk []byte) ([]byte, error) {
+	macFunc, err := MACFor(c.SuiteID)
+	if err != nil {
+		return nil, err
+	}
+	var suiteBin [2]byte
+	binary.BigEndian.PutUint16(suiteBin[:], c.SuiteID)
+
+	h := hmac.New(macFunc, psk)
 	h.Write([]byte("cha"))
+	h.Write(suiteBin[:])
 	h.Write(challenge)
 	h.Write([]byte("acl#" + c.ACLKey))
-	return h.Sum(nil)
+	return h.Sum(nil), nil
 }
 
 func (c *Connect) Len() int {
-	return len([]byte(c.ACLKey)) + len(c.Signature)
+	return 2 + len([]byte(c.ACLKey)) + len(c.Signature)
 }
 
-func (c *Connect) Sign(challenge []byte, psk []byte) {
-	c.Signature = c.HMAC(challenge, psk)
+func (c *Connect) Sign(challenge []byte, psk []byte) error {
+	mac, err := c.HMAC(challenge, psk)
+	if err != nil {
+		return err
+	}
+	c.Signature = mac
+	return nil
 }
 
+// Verify fails closed: an unknown c.SuiteID returns false rather than
+// falling back to a default MAC, so an attacker can't flip the advertised
+// suite to dodge verification.
 func (c *Connect) Verify(challenge []byte, psk []byte) bool {
-	return bytes.Compare(c.Signature, c.HMAC(challenge, psk)) == 0
+	mac, err := c.HMAC(challenge, psk)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(c.Signature, mac)
+}
+
+// VerifyChallenge is the replay-protected counterpart to Verify: it first
+// consults store to make sure h is a challenge the server actually issued,
+// still within its TTL, and not already consumed by a prior Connect, then
+// verifies the signature is bound to h.
+func (c *Connect) VerifyChallenge(store *ChallengeStore, h *Hello, psk []byte) error {
+	if err := store.Validate(h); err != nil {
+		return err
+	}
+	if !c.Verify(h.Bound(), psk) {
+		return ErrInvalidPacket
+	}
+	return nil
 }
 
 func (c *Connect) Encode(buf []byte) ([]byte, error) {
@@ -69,6 +148,8 @@ func (c *Connect) Encode(buf []byte) ([]byte, error) {
 	if len(binACLKey) > 0xFFFF || len(c.Signature) > 0xFFFF {
 		return nil, ErrContentTooLong
 	}
+	binary.BigEndian.PutUint16(lengthBin[:], c.SuiteID)
+	buf = append(buf, lengthBin[:]...)
 	binary.BigEndian.PutUint16(lengthBin[:], uint16(len(binACLKey)))
 	buf = append(buf, lengthBin[:]...)
 	binary.BigEndian.PutUint16(lengthBin[:], uint16(len(c.Signature)))
@@ -80,27 +161,55 @@ func (c *Connect) Encode(buf []byte) ([]byte, error) {
 }
 
 func (c *Connect) Decode(buf []byte) error {
-	if len(buf) < 4 {
+	if len(buf) < 6 {
 		return ErrInvalidPacket
 	}
-	msgLen, signLen := uint16(0), uint16(0)
-	msgLen = binary.BigEndian.Uint16(buf[:2])
-	signLen = binary.BigEndian.Uint16(buf[2:4])
-	if int(msgLen)+int(signLen)+4 > len(buf) {
+	c.SuiteID = binary.BigEndian.Uint16(buf[:2])
+	msgLen := binary.BigEndian.Uint16(buf[2:4])
+	signLen := binary.BigEndian.Uint16(buf[4:6])
+	if 6+int(msgLen)+int(signLen) > len(buf) {
 		return ErrInvalidPacket
 	}
-	c.ACLKey = string(buf[4 : 4+msgLen])
-	c.Signature = buf[4+msgLen : 4+msgLen+signLen]
+	c.ACLKey = string(buf[6 : 6+msgLen])
+	c.Signature = buf[6+msgLen : 6+msgLen+signLen]
 	return nil
 }
 
+// Reason codes explain a Welcome=false ConnectResult. RejectAuth covers a
+// bad signature or unknown ACL key and is never worth retrying as-is; the
+// RejectChallenge* reasons mean the Connect was bound to a Hello the
+// ChallengeStore no longer accepts (see ChallengeStore.Validate), so the
+// client should fetch a fresh Hello and retry rather than give up.
+const (
+	RejectAuth uint8 = iota
+	RejectChallengeExpired
+	RejectChallengeUnknown
+	RejectChallengeConsumed
+)
+
+// ReasonForChallengeErr maps a ChallengeStore.Validate error to the reason
+// code a server should put in its ConnectResult.
+func ReasonForChallengeErr(err error) uint8 {
+	switch err {
+	case ErrChallengeExpired:
+		return RejectChallengeExpired
+	case ErrChallengeUnknown:
+		return RejectChallengeUnknown
+	case ErrChallengeConsumed:
+		return RejectChallengeConsumed
+	default:
+		return RejectAuth
+	}
+}
+
 type ConnectResult struct {
 	Welcome bool
+	Reason  uint8
 	RawMsg  [31]byte
 	MsgLen  int
 }
 
-func (c *ConnectResult) Len() int { return 1 + c.MsgLen }
+func (c *ConnectResult) Len() int { return 2 + c.MsgLen }
 
 func (c *ConnectResult) EncodeMessage(msg string) error {
 	raw := []byte(msg)
@@ -123,21 +232,23 @@ func (c *ConnectResult) Encode(buf []byte) []byte {
 	} else {
 		buf = append(buf, 0)
 	}
+	buf = append(buf, c.Reason)
 	buf = append(buf, byte(c.MsgLen&0xFF))
 	buf = append(buf, c.RawMsg[:c.MsgLen]...)
 	return buf
 }
 
 func (c *ConnectResult) Decode(buf []byte) error {
-	if len(buf) < 2 {
+	if len(buf) < 3 {
 		return ErrInvalidPacket
 	}
-	msgLen := uint8(buf[1])
+	msgLen := uint8(buf[2])
 	c.Welcome = buf[0] > 0
-	if int(2+msgLen) > len(buf) {
+	c.Reason = buf[1]
+	if int(3+msgLen) > len(buf) {
 		return ErrInvalidPacket
 	}
 	c.MsgLen = int(msgLen)
-	copy(c.RawMsg[:c.MsgLen], buf[2:2+c.MsgLen])
+	copy(c.RawMsg[:c.MsgLen], buf[3:3+msgLen])
 	return nil
 }
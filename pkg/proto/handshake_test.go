@@ -0,0 +1,52 @@
+package proto
+
+import "testing"
+
+func TestHelloEncodeDecode(t *testing.T) {
+	h := &Hello{Suites: SuiteHMACSHA256 | SuiteChaCha20Poly1305, Nonce: 42, Issued: 1234567890}
+	h.Refresh()
+
+	buf := h.Encode(nil)
+
+	got := &Hello{}
+	if err := got.Decode(buf); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got.Suites != h.Suites || got.Nonce != h.Nonce || got.Issued != h.Issued || got.Challenge != h.Challenge {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", got, h)
+	}
+}
+
+func TestHelloDecodeRejectsBadMagic(t *testing.T) {
+	h := &Hello{}
+	buf := make([]byte, h.Len())
+	if err := h.Decode(buf); err != ErrInvalidPacket {
+		t.Fatalf("Decode() = %v, want ErrInvalidPacket", err)
+	}
+}
+
+func TestConnectSignVerify(t *testing.T) {
+	psk := []byte("shared-secret")
+	challenge := []byte("challenge-bytes")
+
+	c := &Connect{ACLKey: "alice"}
+	if err := c.Sign(challenge, psk); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	buf, err := c.Encode(nil)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	got := &Connect{}
+	if err := got.Decode(buf); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !got.Verify(challenge, psk) {
+		t.Fatalf("Verify() = false, want true")
+	}
+	if got.Verify([]byte("wrong-challenge"), psk) {
+		t.Fatalf("Verify() with wrong challenge = true, want false")
+	}
+}
@@ -0,0 +1,273 @@
+package proto
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// dataMaxSize bounds a single EncryptedFrame's plaintext so the wire frame
+// (length + nonce + ciphertext + tag) fits comfortably under a typical MTU.
+const dataMaxSize = 1024
+
+const nonceSize = chacha20poly1305.NonceSize // 12
+
+// KeyExchange carries an ephemeral X25519 public key. The signature binds it
+// to the handshake in the same way Connect binds the ACL key: an HMAC over
+// the challenge issued in Hello, keyed by the shared PSK.
+type KeyExchange struct {
+	PubKey    [32]byte
+	Signature []byte
+}
+
+func (k *KeyExchange) HMAC(challenge []byte, psk []byte) []byte {
+	h := hmac.New(sha512.New, psk)
+	h.Write([]byte("kex"))
+	h.Write(challenge)
+	h.Write(k.PubKey[:])
+	return h.Sum(nil)
+}
+
+func (k *KeyExchange) Sign(challenge []byte, psk []byte) {
+	k.Signature = k.HMAC(challenge, psk)
+}
+
+func (k *KeyExchange) Verify(challenge []byte, psk []byte) bool {
+	return hmac.Equal(k.Signature, k.HMAC(challenge, psk))
+}
+
+func (k *KeyExchange) Len() int {
+	return len(k.PubKey) + 2 + len(k.Signature)
+}
+
+func (k *KeyExchange) Encode(buf []byte) ([]byte, error) {
+	if len(k.Signature) > 0xFFFF {
+		return nil, ErrContentTooLong
+	}
+	var lengthBin [2]byte
+	buf = buf[0:0]
+	buf = append(buf, k.PubKey[:]...)
+	binary.BigEndian.PutUint16(lengthBin[:], uint16(len(k.Signature)))
+	buf = append(buf, lengthBin[:]...)
+	buf = append(buf, k.Signature...)
+	return buf, nil
+}
+
+func (k *KeyExchange) Decode(buf []byte) error {
+	if len(buf) < 34 {
+		return ErrInvalidPacket
+	}
+	copy(k.PubKey[:], buf[:32])
+	signLen := binary.BigEndian.Uint16(buf[32:34])
+	if 34+int(signLen) > len(buf) {
+		return ErrInvalidPacket
+	}
+	k.Signature = buf[34 : 34+signLen]
+	return nil
+}
+
+// NewKeyPair generates an ephemeral X25519 keypair for a handshake side.
+func NewKeyPair() (pub [32]byte, priv [32]byte, err error) {
+	if _, err = io.ReadFull(rand.Reader, priv[:]); err != nil {
+		return
+	}
+	pubSlice, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return
+	}
+	copy(pub[:], pubSlice)
+	return
+}
+
+// directionalSecrets derives the send/receive AEAD keys and starting nonces
+// for one side of the exchange. The two public keys are sorted lexically so
+// both peers agree on which HKDF info label is "send" and which is "recv"
+// without an extra round trip.
+func directionalSecrets(sharedSecret, challenge []byte, localPub, remotePub [32]byte) (sendKey, recvKey [32]byte, sendNonce, recvNonce [12]byte, err error) {
+	localIsLower := bytes.Compare(localPub[:], remotePub[:]) < 0
+	lower, upper := localPub, remotePub
+	if !localIsLower {
+		lower, upper = remotePub, localPub
+	}
+
+	ikm := append(append([]byte{}, sharedSecret...), challenge...)
+
+	lowerKey, lowerNonce, err := expandDirectional(ikm, append([]byte("utt-send-"), lower[:]...))
+	if err != nil {
+		return
+	}
+	upperKey, upperNonce, err := expandDirectional(ikm, append([]byte("utt-recv-"), upper[:]...))
+	if err != nil {
+		return
+	}
+
+	if localIsLower {
+		return lowerKey, upperKey, lowerNonce, upperNonce, nil
+	}
+	return upperKey, lowerKey, upperNonce, lowerNonce, nil
+}
+
+func expandDirectional(ikm, info []byte) (key [32]byte, nonce [12]byte, err error) {
+	r := hkdf.New(sha512.New, ikm, nil, info)
+	var out [32 + 12]byte
+	if _, err = io.ReadFull(r, out[:]); err != nil {
+		return
+	}
+	copy(key[:], out[:32])
+	copy(nonce[:], out[32:])
+	return
+}
+
+// incNonce increments a 96-bit little-endian counter nonce in place and
+// reports whether it wrapped, which must trigger a rekey before reuse.
+func incNonce(nonce *[12]byte) (wrapped bool) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// SecretConn wraps a net.Conn established via the X25519 key exchange above,
+// framing plaintext into <=dataMaxSize AEAD records on write and
+// reassembling them on read. Each direction has its own key and nonce
+// counter; a wrapped nonce counter means ErrRekeyRequired is returned
+// instead of silently reusing a nonce.
+type SecretConn struct {
+	net.Conn
+
+	send      cipher.AEAD
+	recv      cipher.AEAD
+	sendNonce [12]byte
+	recvNonce [12]byte
+
+	readBuf []byte
+}
+
+// NewSecretConn builds a SecretConn from the directional keys derived by
+// directionalSecrets, using the AEAD selected by suiteID (see AEADFor). A
+// suiteID of zero defaults to ChaCha20-Poly1305, the only AEAD available
+// before suite negotiation existed.
+func NewSecretConn(conn net.Conn, suiteID uint16, sendKey, recvKey [32]byte, sendNonce, recvNonce [12]byte) (*SecretConn, error) {
+	if suiteID == 0 {
+		suiteID = SuiteChaCha20Poly1305
+	}
+	send, err := AEADFor(suiteID, sendKey[:])
+	if err != nil {
+		return nil, err
+	}
+	recv, err := AEADFor(suiteID, recvKey[:])
+	if err != nil {
+		return nil, err
+	}
+	return &SecretConn{Conn: conn, send: send, recv: recv, sendNonce: sendNonce, recvNonce: recvNonce}, nil
+}
+
+func (c *SecretConn) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > dataMaxSize {
+			chunk = chunk[:dataMaxSize]
+		}
+		if err = c.writeFrame(chunk); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+func (c *SecretConn) writeFrame(plaintext []byte) error {
+	if incWouldWrap(c.sendNonce) {
+		return ErrRekeyRequired
+	}
+	nonce := c.sendNonce
+	sealed := c.send.Seal(nil, nonce[:], plaintext, nil)
+	incNonce(&c.sendNonce)
+
+	var frame [4]byte
+	binary.BigEndian.PutUint32(frame[:], uint32(nonceSize+len(sealed)))
+	if _, err := c.Conn.Write(frame[:]); err != nil {
+		return err
+	}
+	if _, err := c.Conn.Write(nonce[:]); err != nil {
+		return err
+	}
+	_, err := c.Conn.Write(sealed)
+	return err
+}
+
+func (c *SecretConn) Read(p []byte) (int, error) {
+	if len(c.readBuf) == 0 {
+		frame, err := c.readFrame()
+		if err != nil {
+			return 0, err
+		}
+		c.readBuf = frame
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *SecretConn) readFrame() ([]byte, error) {
+	var lengthBin [4]byte
+	if _, err := io.ReadFull(c.Conn, lengthBin[:]); err != nil {
+		c.Conn.Close()
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(lengthBin[:])
+	if length < nonceSize || length > nonceSize+dataMaxSize+chacha20poly1305.Overhead {
+		c.Conn.Close()
+		return nil, ErrInvalidPacket
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.Conn, body); err != nil {
+		c.Conn.Close()
+		return nil, err
+	}
+
+	if incWouldWrap(c.recvNonce) {
+		c.Conn.Close()
+		return nil, ErrRekeyRequired
+	}
+	// The wire nonce must match the next expected counter value: anything
+	// else is a duplicated or reordered frame, which Open alone would
+	// happily decrypt since each frame carries its own nonce and tag.
+	if !bytes.Equal(body[:nonceSize], c.recvNonce[:]) {
+		c.Conn.Close()
+		return nil, ErrDecryption
+	}
+	incNonce(&c.recvNonce)
+
+	plaintext, err := c.recv.Open(nil, body[:nonceSize], body[nonceSize:], nil)
+	if err != nil {
+		c.Conn.Close()
+		return nil, ErrDecryption
+	}
+	return plaintext, nil
+}
+
+// incWouldWrap reports whether incrementing nonce would wrap it back to
+// zero, without mutating it.
+func incWouldWrap(nonce [12]byte) bool {
+	for i := range nonce {
+		if nonce[i] != 0xFF {
+			return false
+		}
+	}
+	return true
+}
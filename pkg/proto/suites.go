@@ -0,0 +1,74 @@
+package proto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Suite bits are advertised by the server in Hello.Suites and selected one
+// at a time by the client via Connect.SuiteID, so a MAC or AEAD primitive
+// can be retired without a wire break: both sides just stop advertising it.
+// A zero Suites field means "legacy HMAC-SHA512 only", matching the
+// pre-negotiation wire format.
+const (
+	SuiteHMACSHA256 uint16 = 1 << iota
+	SuiteHMACSHA512
+	SuiteBLAKE2b256
+	SuiteEd25519
+
+	SuiteChaCha20Poly1305
+	SuiteAES256GCM
+)
+
+// macRegistry maps a single suite bit to the hash constructor used to key
+// an HMAC for it. Ed25519 is a signature scheme rather than a keyed hash,
+// so it has no entry here and MACFor returns ErrUnsupportedSuite for it.
+var macRegistry = map[uint16]func() hash.Hash{
+	SuiteHMACSHA256: sha256.New,
+	SuiteHMACSHA512: sha512.New,
+	SuiteBLAKE2b256: func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	},
+}
+
+// aeadRegistry maps a single suite bit to an AEAD constructor for a given
+// key, used once the encrypted channel negotiates which cipher to run.
+var aeadRegistry = map[uint16]func(key []byte) (cipher.AEAD, error){
+	SuiteChaCha20Poly1305: chacha20poly1305.New,
+	SuiteAES256GCM: func(key []byte) (cipher.AEAD, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	},
+}
+
+// MACFor looks up the hash constructor for a suite bit, falling back to
+// HMAC-SHA512 when id is zero so legacy peers keep working.
+func MACFor(id uint16) (func() hash.Hash, error) {
+	if id == 0 {
+		id = SuiteHMACSHA512
+	}
+	h, ok := macRegistry[id]
+	if !ok {
+		return nil, ErrUnsupportedSuite
+	}
+	return h, nil
+}
+
+// AEADFor looks up the AEAD constructor for a suite bit.
+func AEADFor(id uint16, key []byte) (cipher.AEAD, error) {
+	ctor, ok := aeadRegistry[id]
+	if !ok {
+		return nil, ErrUnsupportedSuite
+	}
+	return ctor(key)
+}
@@ -0,0 +1,42 @@
+package proto
+
+import "testing"
+
+func TestConnectVerifyFailsClosedOnUnknownSuite(t *testing.T) {
+	psk := []byte("shared-secret")
+	challenge := []byte("challenge-bytes")
+
+	c := &Connect{ACLKey: "alice", SuiteID: SuiteHMACSHA256}
+	if err := c.Sign(challenge, psk); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	c.SuiteID = 0xFFFF // not in the registry
+	if c.Verify(challenge, psk) {
+		t.Fatalf("Verify() with unknown SuiteID = true, want false")
+	}
+}
+
+func TestConnectSignRejectsUnknownSuite(t *testing.T) {
+	c := &Connect{ACLKey: "alice", SuiteID: 0xFFFF}
+	if err := c.Sign([]byte("challenge"), []byte("psk")); err != ErrUnsupportedSuite {
+		t.Fatalf("Sign() = %v, want ErrUnsupportedSuite", err)
+	}
+}
+
+func TestConnectVerifyBindsSuiteID(t *testing.T) {
+	psk := []byte("shared-secret")
+	challenge := []byte("challenge-bytes")
+
+	c := &Connect{ACLKey: "alice", SuiteID: SuiteHMACSHA256}
+	if err := c.Sign(challenge, psk); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	// Flipping the advertised suite after signing must invalidate the
+	// signature instead of silently re-verifying under a different MAC.
+	c.SuiteID = SuiteHMACSHA512
+	if c.Verify(challenge, psk) {
+		t.Fatalf("Verify() after flipping SuiteID = true, want false")
+	}
+}
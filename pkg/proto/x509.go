@@ -0,0 +1,200 @@
+package proto
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACLKeyFromCert derives the ACLKey a server should authorize once a
+// ConnectX509's certificate chain has verified, pulling it from whichever
+// certificate field the deployment configures (CN, a SAN URI, or a SPIFFE
+// ID carried as a SAN URI).
+type ACLKeyFromCert func(*x509.Certificate) (string, error)
+
+// ACLKeyFromCommonName is the simplest ACLKeyFromCert: the certificate's
+// Subject Common Name, verbatim.
+func ACLKeyFromCommonName(cert *x509.Certificate) (string, error) {
+	if cert.Subject.CommonName == "" {
+		return "", ErrInvalidPacket
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// ACLKeyFromSANURI returns the first SAN URI on the certificate, which is
+// how SPIFFE IDs (spiffe://trust-domain/workload) are typically carried.
+func ACLKeyFromSANURI(cert *x509.Certificate) (string, error) {
+	if len(cert.URIs) == 0 {
+		return "", ErrInvalidPacket
+	}
+	return cert.URIs[0].String(), nil
+}
+
+// ConnectX509 is an alternative to Connect that authenticates with a client
+// certificate chain instead of an HMAC over a shared PSK: the client signs
+// Hello.Bound() with the leaf certificate's private key, and the server
+// verifies the chain against a *x509.CertPool before trusting it.
+type ConnectX509 struct {
+	SuiteID   uint16
+	Chain     [][]byte // DER-encoded, leaf first
+	Signature []byte
+}
+
+// Sign computes the signature over h.Bound() using priv, which must be an
+// *rsa.PrivateKey (signed with RSA-PSS) or an ed25519.PrivateKey.
+func (c *ConnectX509) Sign(h *Hello, priv interface{}) error {
+	bound := h.Bound()
+	switch key := priv.(type) {
+	case ed25519.PrivateKey:
+		c.Signature = ed25519.Sign(key, bound)
+		return nil
+	case *rsa.PrivateKey:
+		digest := sha256.Sum256(bound)
+		sig, err := rsa.SignPSS(rand.Reader, key, crypto.SHA256, digest[:], nil)
+		if err != nil {
+			return err
+		}
+		c.Signature = sig
+		return nil
+	default:
+		return ErrUnsupportedSuite
+	}
+}
+
+// Verify checks the chain against pool and confirms the leaf certificate's
+// public key produced Signature over h.Bound().
+func (c *ConnectX509) Verify(h *Hello, pool *x509.CertPool) (*x509.Certificate, error) {
+	leaf, intermediates, err := c.parseChain()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		return nil, err
+	}
+
+	bound := h.Bound()
+	switch pub := leaf.PublicKey.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, bound, c.Signature) {
+			return nil, ErrInvalidPacket
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(bound)
+		if err := rsa.VerifyPSS(pub, crypto.SHA256, digest[:], c.Signature, nil); err != nil {
+			return nil, ErrInvalidPacket
+		}
+	default:
+		return nil, ErrUnsupportedSuite
+	}
+	return leaf, nil
+}
+
+func (c *ConnectX509) parseChain() (*x509.Certificate, *x509.CertPool, error) {
+	if len(c.Chain) == 0 {
+		return nil, nil, ErrInvalidPacket
+	}
+	leaf, err := x509.ParseCertificate(c.Chain[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range c.Chain[1:] {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, nil, err
+		}
+		intermediates.AddCert(cert)
+	}
+	return leaf, intermediates, nil
+}
+
+func (c *ConnectX509) Len() int {
+	n := 2 + len(c.Signature)
+	for _, der := range c.Chain {
+		n += 4 + len(der)
+	}
+	return n
+}
+
+func (c *ConnectX509) Encode(buf []byte) ([]byte, error) {
+	if len(c.Chain) > 0xFFFF || len(c.Signature) > 0xFFFF {
+		return nil, ErrContentTooLong
+	}
+	var u16 [2]byte
+	var u32 [4]byte
+	buf = buf[0:0]
+
+	binary.BigEndian.PutUint16(u16[:], c.SuiteID)
+	buf = append(buf, u16[:]...)
+
+	binary.BigEndian.PutUint16(u16[:], uint16(len(c.Chain)))
+	buf = append(buf, u16[:]...)
+	for _, der := range c.Chain {
+		binary.BigEndian.PutUint32(u32[:], uint32(len(der)))
+		buf = append(buf, u32[:]...)
+		buf = append(buf, der...)
+	}
+
+	binary.BigEndian.PutUint16(u16[:], uint16(len(c.Signature)))
+	buf = append(buf, u16[:]...)
+	buf = append(buf, c.Signature...)
+	return buf, nil
+}
+
+func (c *ConnectX509) Decode(buf []byte) error {
+	if len(buf) < 4 {
+		return ErrInvalidPacket
+	}
+	c.SuiteID = binary.BigEndian.Uint16(buf[:2])
+	chainLen := binary.BigEndian.Uint16(buf[2:4])
+	buf = buf[4:]
+
+	c.Chain = make([][]byte, 0, chainLen)
+	for i := uint16(0); i < chainLen; i++ {
+		if len(buf) < 4 {
+			return ErrInvalidPacket
+		}
+		certLen := binary.BigEndian.Uint32(buf[:4])
+		buf = buf[4:]
+		if uint32(len(buf)) < certLen {
+			return ErrInvalidPacket
+		}
+		c.Chain = append(c.Chain, buf[:certLen])
+		buf = buf[certLen:]
+	}
+
+	if len(buf) < 2 {
+		return ErrInvalidPacket
+	}
+	signLen := binary.BigEndian.Uint16(buf[:2])
+	buf = buf[2:]
+	if uint16(len(buf)) < signLen {
+		return ErrInvalidPacket
+	}
+	c.Signature = buf[:signLen]
+	return nil
+}
+
+// NewAutocertManager wires an autocert.Manager for a utt server's
+// TLS-facing control-plane endpoints (not the PSK/X25519 data-plane
+// handshake above), so operators can obtain and rotate certificates from an
+// ACME CA instead of provisioning them by hand.
+func NewAutocertManager(hosts []string, cacheDir string) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(cacheDir),
+	}
+}
@@ -0,0 +1,96 @@
+package proto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func selfSignedClientCert(t *testing.T, cn string) ([]byte, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("ed25519.GenerateKey: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		t.Fatalf("x509.CreateCertificate: %v", err)
+	}
+	return der, priv
+}
+
+func TestConnectX509EncodeDecode(t *testing.T) {
+	der, priv := selfSignedClientCert(t, "alice")
+
+	h := &Hello{}
+	h.Refresh()
+
+	c := &ConnectX509{Chain: [][]byte{der}}
+	if err := c.Sign(h, priv); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	buf, err := c.Encode(nil)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	got := &ConnectX509{}
+	if err := got.Decode(buf); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	pool.AddCert(leaf)
+
+	verified, err := got.Verify(h, pool)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if verified.Subject.CommonName != "alice" {
+		t.Fatalf("Verify() CN = %q, want %q", verified.Subject.CommonName, "alice")
+	}
+
+	aclKey, err := ACLKeyFromCommonName(verified)
+	if err != nil {
+		t.Fatalf("ACLKeyFromCommonName: %v", err)
+	}
+	if aclKey != "alice" {
+		t.Fatalf("ACLKeyFromCommonName() = %q, want %q", aclKey, "alice")
+	}
+}
+
+func TestConnectX509VerifyRejectsUntrustedChain(t *testing.T) {
+	der, priv := selfSignedClientCert(t, "mallory")
+
+	h := &Hello{}
+	h.Refresh()
+
+	c := &ConnectX509{Chain: [][]byte{der}}
+	if err := c.Sign(h, priv); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+
+	if _, err := c.Verify(h, x509.NewCertPool()); err == nil {
+		t.Fatalf("Verify() with empty pool = nil error, want chain verification failure")
+	}
+}